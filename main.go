@@ -1,16 +1,461 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
+	"math"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// latencySamples is the number of most recent requests kept per route.
+const latencySamples = 1024
+
+var errNoThresholdsFile = errors.New("THRESHOLDS_FILE is not configured")
+
+// defaultLocation holds the thresholds served to locations without their own entry.
+const defaultLocation = "default"
+
+type Threshold struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// thresholdInput is a Threshold as decoded from a request body or the
+// thresholds file, with pointers so a missing bound isn't read as 0.
+type thresholdInput struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+type thresholdStore struct {
+	mu        sync.RWMutex
+	path      string
+	locations map[string]map[string]Threshold
+}
+
+// latencyRecorder keeps a ring of recent request durations per route.
+type latencyRecorder struct {
+	mu     sync.Mutex
+	routes map[string]*latencyRing
+}
+
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+	count   int64
+}
+
+type LatencyStats struct {
+	Count int64   `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+}
+
+var (
+	adminToken     string
+	maxQueryLength int
+	maxQueryParams int
+	thresholds     *thresholdStore
+	maintenance    atomic.Bool
+	latencies      = &latencyRecorder{routes: map[string]*latencyRing{}}
+)
+
+// validateThresholds checks that every location maps at least one field to
+// both a min and a max with min <= max, and returns the validated set.
+func validateThresholds(in map[string]map[string]thresholdInput) (map[string]map[string]Threshold, error) {
+	out := make(map[string]map[string]Threshold, len(in))
+	for location, fields := range in {
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("thresholds for %q must map at least one field to {min, max}", location)
+		}
+		t := make(map[string]Threshold, len(fields))
+		for field, th := range fields {
+			if th.Min == nil || th.Max == nil {
+				return nil, fmt.Errorf("threshold for %q in %q: min and max are required", field, location)
+			}
+			if *th.Min > *th.Max {
+				return nil, fmt.Errorf("threshold for %q in %q: min must not exceed max", field, location)
+			}
+			t[field] = Threshold{Min: *th.Min, Max: *th.Max}
+		}
+		out[location] = t
+	}
+	return out, nil
+}
+
+// loadThresholds reads the startup thresholds, treating an unset path or a
+// missing file as an empty configuration.
+func loadThresholds(path string) (map[string]map[string]Threshold, error) {
+	if path == "" {
+		return map[string]map[string]Threshold{}, nil
+	}
+	locations, err := readThresholds(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]map[string]Threshold{}, nil
+	}
+	return locations, err
+}
+
+func readThresholds(path string) (map[string]map[string]Threshold, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var in map[string]map[string]thresholdInput
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	locations, err := validateThresholds(in)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return locations, nil
+}
+
+func newThresholdStore(path string) (*thresholdStore, error) {
+	locations, err := loadThresholds(path)
+	if err != nil {
+		return nil, err
+	}
+	return &thresholdStore{path: path, locations: locations}, nil
+}
+
+func (s *thresholdStore) get(location string) map[string]Threshold {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.locations[location]; ok {
+		return t
+	}
+	if t, ok := s.locations[defaultLocation]; ok {
+		return t
+	}
+	return map[string]Threshold{}
+}
+
+// reload re-reads and validates the backing file, then swaps it in. The
+// current thresholds are kept if no file is configured or it can't be loaded.
+func (s *thresholdStore) reload() error {
+	if s.path == "" {
+		return errNoThresholdsFile
+	}
+	locations, err := readThresholds(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.locations = locations
+	s.mu.Unlock()
+	return nil
+}
+
+// set replaces the thresholds of a location, persisting them to the
+// backing file when one is configured.
+func (s *thresholdStore) set(location string, t map[string]Threshold) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := make(map[string]map[string]Threshold, len(s.locations)+1)
+	for k, v := range s.locations {
+		next[k] = v
+	}
+	next[location] = t
+	if s.path != "" {
+		data, err := json.MarshalIndent(next, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.path, data); err != nil {
+			return err
+		}
+	}
+	s.locations = next
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so a failed write never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (l *latencyRecorder) record(route string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ring, ok := l.routes[route]
+	if !ok {
+		ring = &latencyRing{samples: make([]time.Duration, 0, latencySamples)}
+		l.routes[route] = ring
+	}
+	if len(ring.samples) < latencySamples {
+		ring.samples = append(ring.samples, d)
+	} else {
+		ring.samples[ring.next] = d
+		ring.next = (ring.next + 1) % latencySamples
+	}
+	ring.count++
+}
+
+func (l *latencyRecorder) snapshot() map[string]LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]LatencyStats, len(l.routes))
+	for route, ring := range l.routes {
+		sorted := append([]time.Duration(nil), ring.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		out[route] = LatencyStats{
+			Count: ring.count,
+			P50Ms: percentileMs(sorted, 0.50),
+			P95Ms: percentileMs(sorted, 0.95),
+			P99Ms: percentileMs(sorted, 0.99),
+		}
+	}
+	return out
+}
+
+// percentileMs returns the nearest-rank percentile of sorted durations in milliseconds.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// queryLimitMiddleware rejects requests whose raw query string is too long or
+// carries too many parameters before any handler parses it.
+func queryLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.RawQuery
+		if len(raw) > maxQueryLength {
+			http.Error(w, fmt.Sprintf("query string exceeds %d bytes", maxQueryLength), http.StatusBadRequest)
+			return
+		}
+		if countQueryParams(raw) > maxQueryParams {
+			http.Error(w, fmt.Sprintf("query string exceeds %d parameters", maxQueryParams), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// countQueryParams counts the non-empty &-separated segments of a raw query.
+func countQueryParams(raw string) int {
+	n := 0
+	for _, part := range strings.Split(raw, "&") {
+		if part != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// maintenanceMiddleware rejects mutating requests with 503 while maintenance
+// mode is on. Reads and admin endpoints keep working so the flag can be cleared.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if maintenance.Load() && !strings.HasPrefix(r.URL.Path, "/api/v1/admin/") {
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "service is in maintenance mode, writes are temporarily disabled", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// latencyMiddleware records request durations keyed by the mux pattern that
+// served them, so arbitrary paths don't grow the set of routes.
+func latencyMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		start := time.Now()
+		mux.ServeHTTP(w, r)
+		latencies.record(route, time.Since(start))
+	})
+}
+
 func handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Hello World from Go on Dockploy!")
 }
 
+// thresholdsHandler serves /api/v1/locations/{loc}/thresholds.
+func thresholdsHandler(w http.ResponseWriter, r *http.Request) {
+	location, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/locations/"), "/thresholds")
+	if !ok || location == "" || strings.Contains(location, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, thresholds.get(location))
+	case http.MethodPut:
+		if !isAdmin(r) {
+			http.Error(w, "admin authorization required", http.StatusUnauthorized)
+			return
+		}
+		var body map[string]thresholdInput
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		validated, err := validateThresholds(map[string]map[string]thresholdInput{location: body})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t := validated[location]
+		if err := thresholds.set(location, t); err != nil {
+			log.Printf("save thresholds for %q: %v", location, err)
+			http.Error(w, "failed to save thresholds", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "admin authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `body must be {"enabled": true|false}`, http.StatusBadRequest)
+			return
+		}
+		maintenance.Store(*body.Enabled)
+		log.Printf("maintenance mode set to %t", *body.Enabled)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": maintenance.Load()})
+}
+
+func latenciesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "admin authorization required", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, latencies.snapshot())
+}
+
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "admin authorization required", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := thresholds.reload(); err != nil {
+		log.Printf("reload thresholds: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errNoThresholdsFile) {
+			status = http.StatusConflict
+		}
+		http.Error(w, "failed to reload thresholds: "+err.Error(), status)
+		return
+	}
+	log.Printf("configuration reloaded")
+	writeJSON(w, http.StatusOK, map[string][]string{"reloaded": {"thresholds"}})
+}
+
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handler)
+	mux.HandleFunc("/api/v1/locations/", thresholdsHandler)
+	mux.HandleFunc("/api/v1/admin/maintenance", maintenanceHandler)
+	mux.HandleFunc("/api/v1/admin/latencies", latenciesHandler)
+	mux.HandleFunc("/api/v1/admin/reload", reloadHandler)
+	return queryLimitMiddleware(maintenanceMiddleware(latencyMiddleware(mux)))
+}
+
 func main() {
-	http.HandleFunc("/", handler)
+	var err error
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	maxQueryLength = envInt("MAX_QUERY_LENGTH", 2048)
+	maxQueryParams = envInt("MAX_QUERY_PARAMS", 50)
+	maintenance.Store(os.Getenv("MAINTENANCE_MODE") == "true")
+	thresholds, err = newThresholdStore(os.Getenv("THRESHOLDS_FILE"))
+	if err != nil {
+		log.Fatalf("load thresholds: %v", err)
+	}
+
 	fmt.Println("Server running at :3000")
-	http.ListenAndServe(":3000", nil)
+	http.ListenAndServe(":3000", newRouter())
 }
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testToken = "secret"
+
+func setup(t *testing.T, thresholdsFile string) http.Handler {
+	t.Helper()
+	adminToken = testToken
+	maxQueryLength = 2048
+	maxQueryParams = 50
+	maintenance.Store(false)
+	store, err := newThresholdStore(thresholdsFile)
+	if err != nil {
+		t.Fatalf("newThresholdStore: %v", err)
+	}
+	thresholds = store
+	return newRouter()
+}
+
+func do(h http.Handler, method, target, body string, admin bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+testToken)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeThresholds(t *testing.T, rec *httptest.ResponseRecorder) map[string]Threshold {
+	t.Helper()
+	var got map[string]Threshold
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	return got
+}
+
+func writeThresholdsFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThresholdsPutThenGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thresholds.json")
+	h := setup(t, path)
+
+	rec := do(h, http.MethodPut, "/api/v1/locations/lab/thresholds", `{"temperature":{"min":10,"max":30}}`, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	rec = do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d", rec.Code)
+	}
+	if got := decodeThresholds(t, rec)["temperature"]; got != (Threshold{Min: 10, Max: 30}) {
+		t.Errorf("temperature = %+v, want {10 30}", got)
+	}
+
+	persisted, err := loadThresholds(path)
+	if err != nil {
+		t.Fatalf("loadThresholds: %v", err)
+	}
+	if got := persisted["lab"]["temperature"]; got != (Threshold{Min: 10, Max: 30}) {
+		t.Errorf("persisted temperature = %+v, want {10 30}", got)
+	}
+}
+
+func TestThresholdsPutRequiresAdmin(t *testing.T) {
+	h := setup(t, "")
+
+	rec := do(h, http.MethodPut, "/api/v1/locations/lab/thresholds", `{"temperature":{"min":10,"max":30}}`, false)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if got := decodeThresholds(t, do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false)); len(got) != 0 {
+		t.Errorf("thresholds = %+v, want none", got)
+	}
+}
+
+func TestThresholdsDefaultFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thresholds.json")
+	writeThresholdsFile(t, path, `{"default":{"humidity":{"min":30,"max":60}},"lab":{"temperature":{"min":10,"max":30}}}`)
+	h := setup(t, path)
+
+	got := decodeThresholds(t, do(h, http.MethodGet, "/api/v1/locations/warehouse/thresholds", "", false))
+	if len(got) != 1 || got["humidity"] != (Threshold{Min: 30, Max: 60}) {
+		t.Errorf("warehouse thresholds = %+v, want default entry", got)
+	}
+
+	got = decodeThresholds(t, do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false))
+	if _, ok := got["humidity"]; ok {
+		t.Errorf("lab thresholds = %+v, should not include default entry", got)
+	}
+}
+
+func TestLoadThresholdsRejectsInvalidFile(t *testing.T) {
+	for _, content := range []string{
+		`{"lab":{"temperature":{"min":30,"max":10}}}`,
+		`{"lab":{"temperature":{}}}`,
+		`{"lab":null}`,
+		`not json`,
+	} {
+		path := filepath.Join(t.TempDir(), "thresholds.json")
+		writeThresholdsFile(t, path, content)
+		if _, err := newThresholdStore(path); err == nil {
+			t.Errorf("newThresholdStore(%s) succeeded, want error", content)
+		}
+	}
+}
+
+func TestThresholdsPutValidation(t *testing.T) {
+	h := setup(t, "")
+
+	for _, body := range []string{
+		`{"temperature":{"min":30,"max":10}}`,
+		`{"temperature":{"min":5}}`,
+		`{"temperature":{"max":30}}`,
+		`{"temperature":{}}`,
+		`null`,
+		`{}`,
+		`not json`,
+	} {
+		rec := do(h, http.MethodPut, "/api/v1/locations/lab/thresholds", body, true)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestMaintenanceModeRejectsWrites(t *testing.T) {
+	h := setup(t, "")
+
+	rec := do(h, http.MethodPut, "/api/v1/admin/maintenance", `{"enabled":true}`, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enable: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	rec = do(h, http.MethodPost, "/", "", false)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST status = %d, want 503", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("POST response is missing Retry-After")
+	}
+	rec = do(h, http.MethodPut, "/api/v1/locations/lab/thresholds", `{"temperature":{"min":10,"max":30}}`, true)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("threshold PUT status = %d, want 503", rec.Code)
+	}
+
+	if rec := do(h, http.MethodGet, "/", "", false); rec.Code != http.StatusOK {
+		t.Errorf("GET / status = %d, want 200", rec.Code)
+	}
+	if rec := do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false); rec.Code != http.StatusOK {
+		t.Errorf("GET thresholds status = %d, want 200", rec.Code)
+	}
+
+	rec = do(h, http.MethodPut, "/api/v1/admin/maintenance", `{"enabled":false}`, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("disable: status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if rec := do(h, http.MethodPost, "/", "", false); rec.Code != http.StatusOK {
+		t.Errorf("POST after disable: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLatenciesPerRoute(t *testing.T) {
+	h := setup(t, "")
+	latencies = &latencyRecorder{routes: map[string]*latencyRing{}}
+
+	for i := 0; i < 3; i++ {
+		do(h, http.MethodGet, "/", "", false)
+		do(h, http.MethodGet, "/some/unknown/path", "", false)
+		do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false)
+	}
+
+	rec := do(h, http.MethodGet, "/api/v1/admin/latencies", "", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	var got map[string]map[string]json.Number
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+
+	want := map[string]string{"/": "6", "/api/v1/locations/": "3"}
+	for route, count := range want {
+		stats, ok := got[route]
+		if !ok {
+			t.Errorf("missing route %q in %v", route, got)
+			continue
+		}
+		for _, key := range []string{"count", "p50_ms", "p95_ms", "p99_ms"} {
+			if _, ok := stats[key]; !ok {
+				t.Errorf("route %q missing %q", route, key)
+			}
+		}
+		if stats["count"].String() != count {
+			t.Errorf("route %q count = %s, want %s", route, stats["count"], count)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("routes = %v, want only %v", got, want)
+	}
+}
+
+func TestLatenciesGetOnly(t *testing.T) {
+	h := setup(t, "")
+
+	rec := do(h, http.MethodDelete, "/api/v1/admin/latencies", "", true)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET" {
+		t.Errorf("Allow = %q, want GET", got)
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	h := setup(t, "")
+	maxQueryLength = 64
+	maxQueryParams = 3
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"at length limit", "a=" + strings.Repeat("x", 62), http.StatusOK},
+		{"over length limit", "a=" + strings.Repeat("x", 63), http.StatusBadRequest},
+		{"at param limit", "a=1&b=2&c=3", http.StatusOK},
+		{"over param limit", "a=1&b=2&c=3&d=4", http.StatusBadRequest},
+		{"empty segments", strings.Repeat("&", 60), http.StatusOK},
+		{"empty segments around params", "&&a=1&&b=2&&c=3&&", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(h, http.MethodGet, "/?"+tt.query, "", false)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReloadAppliesChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thresholds.json")
+	writeThresholdsFile(t, path, `{"lab":{"temperature":{"min":10,"max":30}}}`)
+	h := setup(t, path)
+
+	writeThresholdsFile(t, path, `{"lab":{"temperature":{"min":15,"max":25}}}`)
+	rec := do(h, http.MethodPost, "/api/v1/admin/reload", "", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	got := decodeThresholds(t, do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false))
+	if got["temperature"] != (Threshold{Min: 15, Max: 25}) {
+		t.Errorf("temperature = %+v, want {15 25}", got["temperature"])
+	}
+}
+
+func TestReloadKeepsThresholdsOnFailure(t *testing.T) {
+	want := Threshold{Min: 10, Max: 30}
+
+	tests := []struct {
+		name   string
+		file   func(t *testing.T, path string)
+		noFile bool
+		status int
+	}{
+		{"broken file", func(t *testing.T, path string) { writeThresholdsFile(t, path, "not json") }, false, http.StatusInternalServerError},
+		{"inverted range", func(t *testing.T, path string) {
+			writeThresholdsFile(t, path, `{"lab":{"temperature":{"min":30,"max":10}}}`)
+		}, false, http.StatusInternalServerError},
+		{"null and empty entries", func(t *testing.T, path string) {
+			writeThresholdsFile(t, path, `{"lab":null,"y":{"temperature":{}}}`)
+		}, false, http.StatusInternalServerError},
+		{"missing file", func(t *testing.T, path string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}, false, http.StatusInternalServerError},
+		{"no file configured", nil, true, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if !tt.noFile {
+				path = filepath.Join(t.TempDir(), "thresholds.json")
+			}
+			h := setup(t, path)
+			rec := do(h, http.MethodPut, "/api/v1/locations/lab/thresholds", `{"temperature":{"min":10,"max":30}}`, true)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("PUT status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			if tt.file != nil {
+				tt.file(t, path)
+			}
+
+			rec = do(h, http.MethodPost, "/api/v1/admin/reload", "", true)
+			if rec.Code != tt.status {
+				t.Errorf("reload status = %d, want %d", rec.Code, tt.status)
+			}
+
+			got := decodeThresholds(t, do(h, http.MethodGet, "/api/v1/locations/lab/thresholds", "", false))
+			if got["temperature"] != want {
+				t.Errorf("temperature = %+v, want %+v", got["temperature"], want)
+			}
+		})
+	}
+}